@@ -0,0 +1,137 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdserver
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/coreos/etcd/pkg/types"
+)
+
+// Cluster tracks the set of members of an etcd cluster and the ids that
+// have been permanently removed from it. It is the in-memory view the
+// server consults to look members up, add them, and remove them.
+type Cluster struct {
+	name string
+
+	sync.Mutex // guards members, removed and health
+	members    map[types.ID]*Member
+	// removed holds the ids of members that used to be part of the
+	// cluster. Once an id is removed it must never be reused.
+	removed map[types.ID]bool
+	// health holds the per-member failure-detector state maintained by
+	// a Prober. See member_health.go.
+	health map[types.ID]*MemberHealth
+	// idCounter backs MonotonicGenerator. It is seeded from the highest
+	// member id present at construction time (see NewClusterFromMembers)
+	// so a restarted process resumes past ids already in use, but it is
+	// otherwise just an in-memory counter, not itself persisted or
+	// replicated through raft. See member_idgen.go.
+	idCounter uint64
+
+	// peerAuth and peerAuthRequired configure AdmitMember's signature
+	// checking. See member_auth.go.
+	peerAuth         PeerAuthenticator
+	peerAuthRequired bool
+}
+
+// NewClusterFromMembers creates a Cluster from a cluster name and an
+// initial set of members. It is used during bootstrap.
+//
+// idCounter is seeded from the highest id among membs, so that a
+// process restarting with MonotonicGenerator resumes counting above
+// every id already in use instead of re-walking over them from zero.
+func NewClusterFromMembers(name string, membs []*Member) *Cluster {
+	c := &Cluster{
+		name:    name,
+		members: make(map[types.ID]*Member),
+		removed: make(map[types.ID]bool),
+	}
+	for _, m := range membs {
+		c.members[m.ID] = m.Clone()
+		if uint64(m.ID) > c.idCounter {
+			c.idCounter = uint64(m.ID)
+		}
+	}
+	return c
+}
+
+// ID returns the cluster's name.
+func (c *Cluster) ID() string { return c.name }
+
+// Members returns a sorted slice of clones of the cluster's members.
+func (c *Cluster) Members() []*Member {
+	c.Lock()
+	defer c.Unlock()
+	var ms SortableMemberSlice
+	for _, m := range c.members {
+		ms = append(ms, m.Clone())
+	}
+	sort.Sort(ms)
+	return []*Member(ms)
+}
+
+// Member returns the member with the given id, or nil if there is none.
+func (c *Cluster) Member(id types.ID) *Member {
+	c.Lock()
+	defer c.Unlock()
+	return c.members[id].Clone()
+}
+
+// IsIDRemoved reports whether id was once a cluster member and has since
+// been removed.
+func (c *Cluster) IsIDRemoved(id types.ID) bool {
+	c.Lock()
+	defer c.Unlock()
+	return c.removed[id]
+}
+
+// AddMember puts m into the cluster's member set.
+func (c *Cluster) AddMember(m *Member) {
+	c.Lock()
+	defer c.Unlock()
+	c.members[m.ID] = m
+}
+
+// RemoveMember takes id out of the cluster's member set and marks it as
+// removed so the id can never be reused.
+func (c *Cluster) RemoveMember(id types.ID) {
+	c.Lock()
+	defer c.Unlock()
+	delete(c.members, id)
+	c.removed[id] = true
+	delete(c.health, id)
+}
+
+// SetMemberLabels replaces the labels of the member with the given id.
+// As with AddMember/RemoveMember, callers are expected to have already
+// committed the update through raft; SetMemberLabels only updates the
+// local view.
+func (c *Cluster) SetMemberLabels(id types.ID, labels map[string]string) error {
+	c.Lock()
+	defer c.Unlock()
+	m, ok := c.members[id]
+	if !ok {
+		return fmt.Errorf("etcdserver: member %s not found", id)
+	}
+	cp := make(map[string]string, len(labels))
+	for k, v := range labels {
+		cp[k] = v
+	}
+	m.Labels = cp
+	return nil
+}
@@ -15,8 +15,6 @@
 package etcdserver
 
 import (
-	"crypto/sha1"
-	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -24,7 +22,6 @@ import (
 	"math/rand"
 	"net/http"
 	"path"
-	"sort"
 	"time"
 
 	"github.com/coreos/etcd/pkg/types"
@@ -42,6 +39,23 @@ type RaftAttributes struct {
 type Attributes struct {
 	Name       string   `json:"name,omitempty"`
 	ClientURLs []string `json:"clientURLs,omitempty"`
+	// Capability is the highest attribute-encoding version this member
+	// understands. See member_encoding.go. Absent/zero means legacy,
+	// raw-JSON-only.
+	Capability int `json:"capability,omitempty"`
+	// Labels holds arbitrary operator-assigned metadata (zone, region,
+	// role, hardware class, ...) used for topology-aware peer
+	// selection. See member_topology.go.
+	Labels map[string]string `json:"labels,omitempty"`
+	// IssuedAt is the Unix time at which Signature was produced. It is
+	// part of the canonical form Signature is computed over, so a
+	// member can be re-signed without reusing a stale signature.
+	IssuedAt int64 `json:"issuedAt,omitempty"`
+	// Signature is a JWT over this member's canonical identity claims
+	// (id, peer URLs, cluster name, IssuedAt), proving that whoever
+	// registered these peer URLs holds the private key for this
+	// member's id. See member_auth.go.
+	Signature string `json:"signature,omitempty"`
 }
 
 type Member struct {
@@ -51,35 +65,56 @@ type Member struct {
 }
 
 // NewMember creates a Member without an ID and generates one based on the
-// name, peer URLs. This is used for bootstrapping/adding new member.
+// peer URLs, the cluster name and, if now is non-nil, the time, using the
+// default SHA1Generator scheme. This is used for bootstrapping/adding new
+// member.
 func NewMember(name string, peerURLs types.URLs, clusterName string, now *time.Time) *Member {
+	return NewMemberWithGenerator(SHA1Generator{}, name, peerURLs, clusterName, now)
+}
+
+// NewMemberWithGenerator is like NewMember but derives the member's ID
+// using gen instead of always hashing with SHA-1. See MemberIDGenerator.
+func NewMemberWithGenerator(gen MemberIDGenerator, name string, peerURLs types.URLs, clusterName string, now *time.Time) *Member {
 	m := &Member{
 		RaftAttributes: RaftAttributes{PeerURLs: peerURLs.StringSlice()},
-		Attributes:     Attributes{Name: name},
-	}
-
-	var b []byte
-	sort.Strings(m.PeerURLs)
-	for _, p := range m.PeerURLs {
-		b = append(b, []byte(p)...)
+		Attributes:     Attributes{Name: name, Capability: CurrentMemberCapability},
 	}
+	m.ID = gen.Generate(name, peerURLs, clusterName, now)
+	return m
+}
 
-	b = append(b, []byte(clusterName)...)
+// NewSignedMember is like NewMemberWithGenerator, but additionally signs
+// the resulting Member with auth, so peers running with
+// --peer-auth=required can admit it. The member's id becomes part of
+// what's signed, binding the signature to this specific id.
+func NewSignedMember(gen MemberIDGenerator, auth PeerAuthenticator, name string, peerURLs types.URLs, clusterName string, now *time.Time) (*Member, error) {
+	m := NewMemberWithGenerator(gen, name, peerURLs, clusterName, now)
 	if now != nil {
-		b = append(b, []byte(fmt.Sprintf("%d", now.Unix()))...)
+		m.IssuedAt = now.Unix()
+	} else {
+		m.IssuedAt = time.Now().Unix()
 	}
-
-	hash := sha1.Sum(b)
-	m.ID = types.ID(binary.BigEndian.Uint64(hash[:8]))
-	return m
+	sig, err := auth.Sign(m)
+	if err != nil {
+		return nil, err
+	}
+	m.Signature = sig
+	return m, nil
 }
 
-// PickPeerURL chooses a random address from a given Member's PeerURLs.
-// It will panic if there is no PeerURLs available in Member.
-func (m *Member) PickPeerURL() string {
+// PickPeerURL chooses an address from a given Member's PeerURLs. If sel
+// is non-nil and its SelectPeerURL returns a non-empty URL, that URL is
+// used; otherwise PickPeerURL falls back to choosing uniformly at
+// random. It will panic if there is no PeerURLs available in Member.
+func (m *Member) PickPeerURL(sel PeerSelector) string {
 	if len(m.PeerURLs) == 0 {
 		log.Panicf("member should always have some peer url")
 	}
+	if sel != nil {
+		if u := sel.SelectPeerURL(m); u != "" {
+			return u
+		}
+	}
 	return m.PeerURLs[rand.Intn(len(m.PeerURLs))]
 }
 
@@ -90,7 +125,10 @@ func (m *Member) Clone() *Member {
 	mm := &Member{
 		ID: m.ID,
 		Attributes: Attributes{
-			Name: m.Name,
+			Name:       m.Name,
+			Capability: m.Capability,
+			IssuedAt:   m.IssuedAt,
+			Signature:  m.Signature,
 		},
 	}
 	if m.PeerURLs != nil {
@@ -101,6 +139,12 @@ func (m *Member) Clone() *Member {
 		mm.ClientURLs = make([]string, len(m.ClientURLs))
 		copy(mm.ClientURLs, m.ClientURLs)
 	}
+	if m.Labels != nil {
+		mm.Labels = make(map[string]string, len(m.Labels))
+		for k, v := range m.Labels {
+			mm.Labels[k] = v
+		}
+	}
 	return mm
 }
 
@@ -138,14 +182,14 @@ func nodeToMember(n *store.NodeExtern) (*Member, error) {
 		attrs[nn.Key] = []byte(*nn.Value)
 	}
 	if data := attrs[raftAttrKey]; data != nil {
-		if err := json.Unmarshal(data, &m.RaftAttributes); err != nil {
+		if err := unmarshalAttr(data, &m.RaftAttributes); err != nil {
 			return nil, fmt.Errorf("unmarshal raftAttributes error: %v", err)
 		}
 	} else {
 		return nil, fmt.Errorf("raftAttributes key doesn't exist")
 	}
 	if data := attrs[attrKey]; data != nil {
-		if err := json.Unmarshal(data, &m.Attributes); err != nil {
+		if err := unmarshalAttr(data, &m.Attributes); err != nil {
 			return m, fmt.Errorf("unmarshal attributes error: %v", err)
 		}
 	}
@@ -0,0 +1,316 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdserver
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	jwt "github.com/dgrijalva/jwt-go"
+
+	"github.com/coreos/etcd/pkg/types"
+)
+
+// PeerAuthenticator signs and verifies the cryptographic binding between
+// a Member's id and the peer URLs it claims, so that joining the
+// cluster at a given id requires holding that id's private key rather
+// than just knowing the cluster token.
+type PeerAuthenticator interface {
+	// Sign returns the value to store in m.Signature.
+	Sign(m *Member) (string, error)
+	// Verify reports whether m.Signature is a valid signature over m's
+	// canonical claims.
+	Verify(m *Member) error
+}
+
+// peerClaims is the canonical, signed form of a Member's identity.
+type peerClaims struct {
+	jwt.StandardClaims
+	PeerURLs    []string `json:"peerURLs"`
+	ClusterName string   `json:"clusterName"`
+}
+
+func canonicalClaims(m *Member, clusterName string) peerClaims {
+	urls := append([]string(nil), m.PeerURLs...)
+	sort.Strings(urls)
+	return peerClaims{
+		StandardClaims: jwt.StandardClaims{
+			Id:       m.ID.String(),
+			IssuedAt: m.IssuedAt,
+		},
+		PeerURLs:    urls,
+		ClusterName: clusterName,
+	}
+}
+
+// JWKSource resolves a member id (the JWT "kid") to the public key that
+// should have signed its records. Implementations may be backed by a
+// static file, a URL, or a set self-hosted and rotated by the leader.
+type JWKSource interface {
+	Key(id types.ID) (crypto.PublicKey, error)
+}
+
+// JWTPeerAuthenticator is a PeerAuthenticator backed by per-member
+// asymmetric keys (RSA or ECDSA), signing and verifying canonical
+// Member claims as a JWT whose "kid" header is the member id.
+type JWTPeerAuthenticator struct {
+	// SigningKey signs new Member records. May be nil on members that
+	// only verify others' signatures.
+	SigningKey crypto.Signer
+	// SigningMethod matches SigningKey, e.g. jwt.SigningMethodRS256 or
+	// jwt.SigningMethodES256.
+	SigningMethod jwt.SigningMethod
+	// Keys resolves a member id to the public key expected to have
+	// signed its records.
+	Keys JWKSource
+	// ClusterName is included in signed claims and checked on Verify.
+	ClusterName string
+}
+
+// Sign implements PeerAuthenticator.
+func (a JWTPeerAuthenticator) Sign(m *Member) (string, error) {
+	if a.SigningKey == nil {
+		return "", fmt.Errorf("peer auth: no signing key configured")
+	}
+	tok := jwt.NewWithClaims(a.SigningMethod, canonicalClaims(m, a.ClusterName))
+	tok.Header["kid"] = m.ID.String()
+	return tok.SignedString(a.SigningKey)
+}
+
+// Verify implements PeerAuthenticator.
+func (a JWTPeerAuthenticator) Verify(m *Member) error {
+	if m.Signature == "" {
+		return fmt.Errorf("peer auth: member %s has no signature", m.ID)
+	}
+
+	want := canonicalClaims(m, a.ClusterName)
+	got := &peerClaims{}
+	_, err := jwt.ParseWithClaims(m.Signature, got, func(tok *jwt.Token) (interface{}, error) {
+		kid, _ := tok.Header["kid"].(string)
+		if kid != m.ID.String() {
+			return nil, fmt.Errorf("kid %q does not match member id %s", kid, m.ID)
+		}
+		return a.Keys.Key(m.ID)
+	})
+	if err != nil {
+		return fmt.Errorf("peer auth: %v", err)
+	}
+	if got.Id != want.Id || got.ClusterName != want.ClusterName || got.IssuedAt != want.IssuedAt || !equalStringSlices(got.PeerURLs, want.PeerURLs) {
+		return fmt.Errorf("peer auth: signed claims do not match member %s", m.ID)
+	}
+	return nil
+}
+
+func equalStringSlices(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// StaticJWKSource is a JWKSource backed by an in-memory map, suitable
+// for a static key file at startup and as the target of key rotation
+// requests thereafter.
+type StaticJWKSource struct {
+	mu   sync.Mutex
+	keys map[types.ID]crypto.PublicKey
+}
+
+// NewStaticJWKSource creates an empty StaticJWKSource.
+func NewStaticJWKSource() *StaticJWKSource {
+	return &StaticJWKSource{keys: make(map[types.ID]crypto.PublicKey)}
+}
+
+// Key implements JWKSource.
+func (s *StaticJWKSource) Key(id types.ID) (crypto.PublicKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("peer auth: no key known for member %s", id)
+	}
+	return k, nil
+}
+
+// SetKey rotates the public key associated with id.
+func (s *StaticJWKSource) SetKey(id types.ID, key crypto.PublicKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[id] = key
+}
+
+// SetPeerAuthenticator configures the PeerAuthenticator the cluster
+// verifies joining and existing members against. required mirrors
+// --peer-auth=required: when true, members without a valid signature
+// are rejected by AdmitMember.
+func (c *Cluster) SetPeerAuthenticator(auth PeerAuthenticator, required bool) {
+	c.Lock()
+	defer c.Unlock()
+	c.peerAuth = auth
+	c.peerAuthRequired = required
+}
+
+// AdmitMember verifies m against the cluster's configured
+// PeerAuthenticator, if any, before adding it. With peer auth required,
+// an unsigned or invalidly-signed member is rejected outright; with
+// peer auth merely configured but optional, invalid signatures are
+// still rejected but unsigned members are admitted for backwards
+// compatibility with peers that predate --peer-auth.
+//
+// Two checks apply regardless of the above policy. m.ID is never
+// admitted if it was previously removed from the cluster (cluster.go's
+// "once removed, never reused" invariant), and an update to an already
+// -known member always requires a valid signature when an authenticator
+// is configured: the backwards-compatibility carve-out for unsigned
+// members only covers members joining under a new id, not silently
+// overwriting one that already exists.
+func (c *Cluster) AdmitMember(m *Member) error {
+	c.Lock()
+	auth := c.peerAuth
+	required := c.peerAuthRequired
+	_, exists := c.members[m.ID]
+	removed := c.removed[m.ID]
+	c.Unlock()
+
+	if removed {
+		return fmt.Errorf("peer auth: member %s rejected: id was previously removed from the cluster and must never be reused", m.ID)
+	}
+
+	switch {
+	case auth != nil && m.Signature != "":
+		if err := auth.Verify(m); err != nil {
+			return err
+		}
+	case auth != nil && exists:
+		return fmt.Errorf("peer auth: member %s rejected: updates to an existing member require a valid signature", m.ID)
+	case required:
+		return fmt.Errorf("peer auth: member %s rejected: peer authentication is required", m.ID)
+	}
+
+	c.AddMember(m)
+	return nil
+}
+
+// keyRotationAudience scopes a proof-of-possession token to key
+// rotation. rotationClaims is a distinct shape from peerClaims so that
+// an ordinary membership Signature — published in the clear as part of
+// a member's Attributes, and so observable by anyone, not just its
+// holder — can never be replayed as a rotation proof, even though both
+// may be signed with the same key.
+const keyRotationAudience = "key-rotation"
+
+// rotationClaims is the canonical, signed form of a key-rotation proof:
+// a one-time, purpose-bound statement that the caller holds id's
+// current private key, scoped away from any other use of that key.
+type rotationClaims struct {
+	jwt.StandardClaims
+	Audience string `json:"aud"`
+	Nonce    string `json:"nonce"`
+}
+
+// SignKeyRotationProof mints a proof-of-possession token scoped to
+// rotating id's key: the Authorization: Bearer value ServeMemberKeyRotation
+// requires. key/method must be the member's *current* signing key, the
+// one being rotated away from.
+func SignKeyRotationProof(key crypto.Signer, method jwt.SigningMethod, id types.ID) (string, error) {
+	var nonce [16]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return "", fmt.Errorf("peer auth: failed to generate rotation nonce: %v", err)
+	}
+	claims := rotationClaims{
+		StandardClaims: jwt.StandardClaims{Id: id.String()},
+		Audience:       keyRotationAudience,
+		Nonce:          hex.EncodeToString(nonce[:]),
+	}
+	tok := jwt.NewWithClaims(method, claims)
+	return tok.SignedString(key)
+}
+
+// ServeMemberKeyRotation implements POST /v2/members/{id}/keys. Rotation
+// requires proof of possession of the member's *current* key: the
+// request must carry an "Authorization: Bearer <jwt>" header, minted by
+// SignKeyRotationProof with that key, whose jti (Id) claim matches id. Without
+// a key already on file for id, rotation is refused outright — the first
+// key for a given id must be provisioned out of band (by the leader at
+// bootstrap, or whatever mechanism seeds src), never self-registered over
+// this endpoint, or anyone reaching it could claim an arbitrary member id.
+func ServeMemberKeyRotation(src *StaticJWKSource, method jwt.SigningMethod, id types.ID, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	oldKey, err := src.Key(id)
+	if err != nil {
+		http.Error(w, "peer auth: no key on file for this member; rotation requires an already-provisioned key", http.StatusForbidden)
+		return
+	}
+
+	proof := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if proof == "" {
+		http.Error(w, "peer auth: missing proof-of-possession token", http.StatusUnauthorized)
+		return
+	}
+	claims := &rotationClaims{}
+	if _, err := jwt.ParseWithClaims(proof, claims, func(tok *jwt.Token) (interface{}, error) {
+		if tok.Method.Alg() != method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method %q", tok.Method.Alg())
+		}
+		return oldKey, nil
+	}); err != nil {
+		http.Error(w, fmt.Sprintf("peer auth: proof-of-possession failed: %v", err), http.StatusUnauthorized)
+		return
+	}
+	if claims.Audience != keyRotationAudience {
+		http.Error(w, "peer auth: token is not scoped to key rotation", http.StatusUnauthorized)
+		return
+	}
+	if claims.Id != id.String() {
+		http.Error(w, "peer auth: proof-of-possession token id does not match member id", http.StatusUnauthorized)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	block, _ := pem.Decode(body)
+	if block == nil {
+		http.Error(w, "peer auth: no PEM block found in request body", http.StatusBadRequest)
+		return
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	src.SetKey(id, pub)
+	w.WriteHeader(http.StatusNoContent)
+}
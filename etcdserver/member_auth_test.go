@@ -0,0 +1,250 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdserver
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	jwt "github.com/dgrijalva/jwt-go"
+
+	"github.com/coreos/etcd/pkg/types"
+)
+
+func newTestJWTAuthenticator(t *testing.T, id types.ID) (JWTPeerAuthenticator, *StaticJWKSource) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	keys := NewStaticJWKSource()
+	keys.SetKey(id, &key.PublicKey)
+	auth := JWTPeerAuthenticator{
+		SigningKey:    key,
+		SigningMethod: jwt.SigningMethodRS256,
+		Keys:          keys,
+		ClusterName:   "test-cluster",
+	}
+	return auth, keys
+}
+
+func TestJWTPeerAuthenticatorSignAndVerify(t *testing.T) {
+	m := &Member{ID: 42, RaftAttributes: RaftAttributes{PeerURLs: []string{"http://a:1", "http://b:1"}}}
+	m.IssuedAt = 1700000000
+
+	auth, _ := newTestJWTAuthenticator(t, m.ID)
+
+	sig, err := auth.Sign(m)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	m.Signature = sig
+
+	if err := auth.Verify(m); err != nil {
+		t.Fatalf("Verify of a freshly signed member failed: %v", err)
+	}
+}
+
+func TestJWTPeerAuthenticatorRejectsTamperedPeerURLs(t *testing.T) {
+	m := &Member{ID: 42, RaftAttributes: RaftAttributes{PeerURLs: []string{"http://a:1"}}}
+	m.IssuedAt = 1700000000
+
+	auth, _ := newTestJWTAuthenticator(t, m.ID)
+
+	sig, err := auth.Sign(m)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	m.Signature = sig
+
+	tampered := m.Clone()
+	tampered.PeerURLs = append(tampered.PeerURLs, "http://evil:1")
+	if err := auth.Verify(tampered); err == nil {
+		t.Fatalf("expected Verify to reject a member whose peer URLs changed after signing")
+	}
+}
+
+func TestJWTPeerAuthenticatorRejectsTamperedIssuedAt(t *testing.T) {
+	m := &Member{ID: 42, RaftAttributes: RaftAttributes{PeerURLs: []string{"http://a:1"}}}
+	m.IssuedAt = 1700000000
+
+	auth, _ := newTestJWTAuthenticator(t, m.ID)
+
+	sig, err := auth.Sign(m)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	m.Signature = sig
+
+	// IssuedAt is documented (member.go) as part of the canonical form
+	// Signature is computed over, so it can't be changed independently
+	// of re-signing. Changing it without updating Signature must
+	// invalidate verification, exactly like tampering with PeerURLs.
+	m.IssuedAt = 1800000000
+	if err := auth.Verify(m); err == nil {
+		t.Fatalf("expected Verify to reject a member whose IssuedAt changed after signing")
+	}
+}
+
+func TestJWTPeerAuthenticatorRejectsUnsignedMember(t *testing.T) {
+	m := &Member{ID: 42, RaftAttributes: RaftAttributes{PeerURLs: []string{"http://a:1"}}}
+	auth, _ := newTestJWTAuthenticator(t, m.ID)
+
+	if err := auth.Verify(m); err == nil {
+		t.Fatalf("expected Verify to reject a member with no signature")
+	}
+}
+
+func TestClusterAdmitMemberRequiresSignatureWhenRequired(t *testing.T) {
+	c := NewClusterFromMembers("test-cluster", nil)
+	m := &Member{ID: 42, RaftAttributes: RaftAttributes{PeerURLs: []string{"http://a:1"}}}
+	auth, _ := newTestJWTAuthenticator(t, m.ID)
+	c.SetPeerAuthenticator(auth, true)
+
+	if err := c.AdmitMember(m); err == nil {
+		t.Fatalf("expected AdmitMember to reject an unsigned member when peer auth is required")
+	}
+
+	sig, err := auth.Sign(m)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	m.Signature = sig
+	if err := c.AdmitMember(m); err != nil {
+		t.Fatalf("AdmitMember rejected a validly signed member: %v", err)
+	}
+	if c.Member(m.ID) == nil {
+		t.Fatalf("member was not added to the cluster")
+	}
+}
+
+func TestClusterAdmitMemberRejectsRemovedID(t *testing.T) {
+	c := NewClusterFromMembers("test-cluster", []*Member{{ID: 42}})
+	c.RemoveMember(42)
+
+	m := &Member{ID: 42, RaftAttributes: RaftAttributes{PeerURLs: []string{"http://attacker:1"}}}
+	if err := c.AdmitMember(m); err == nil {
+		t.Fatalf("expected AdmitMember to reject a resurrected, previously-removed id")
+	}
+	if c.Member(42) != nil {
+		t.Fatalf("a removed id must not be resurrected by AdmitMember")
+	}
+}
+
+func TestClusterAdmitMemberRejectsUnsignedOverwriteOfExistingMember(t *testing.T) {
+	live := &Member{ID: 42, RaftAttributes: RaftAttributes{PeerURLs: []string{"http://real:1"}}}
+	c := NewClusterFromMembers("test-cluster", []*Member{live})
+	auth, _ := newTestJWTAuthenticator(t, 42)
+	c.SetPeerAuthenticator(auth, false) // peer auth configured but optional
+
+	attacker := &Member{ID: 42, RaftAttributes: RaftAttributes{PeerURLs: []string{"http://attacker:1"}}}
+	if err := c.AdmitMember(attacker); err == nil {
+		t.Fatalf("expected AdmitMember to reject an unsigned update to an already-known member even when peer auth is optional")
+	}
+	if got := c.Member(42); len(got.PeerURLs) != 1 || got.PeerURLs[0] != "http://real:1" {
+		t.Fatalf("existing member's PeerURLs were overwritten: %+v", got)
+	}
+}
+
+func pemEncodePublicKey(t *testing.T, pub interface{}) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatalf("MarshalPKIXPublicKey: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+func TestServeMemberKeyRotationRequiresRotationScopedProof(t *testing.T) {
+	id := types.ID(42)
+	oldKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	src := NewStaticJWKSource()
+	src.SetKey(id, &oldKey.PublicKey)
+
+	// An ordinary membership Signature, signed with the same key, must
+	// not be accepted as a rotation proof: it carries no "aud" claim
+	// scoping it to rotation, and it's published in the clear as part
+	// of the member's Attributes, so anyone observing it could replay
+	// it if it worked.
+	m := &Member{ID: id, RaftAttributes: RaftAttributes{PeerURLs: []string{"http://a:1"}}}
+	auth := JWTPeerAuthenticator{SigningKey: oldKey, SigningMethod: jwt.SigningMethodRS256, Keys: src, ClusterName: "test"}
+	membershipSig, err := auth.Sign(m)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+
+	body := pemEncodePublicKey(t, &newKey.PublicKey)
+	req := httptest.NewRequest(http.MethodPost, "/v2/members/"+id.String()+"/keys", strings.NewReader(string(body)))
+	req.Header.Set("Authorization", "Bearer "+membershipSig)
+	w := httptest.NewRecorder()
+	ServeMemberKeyRotation(src, jwt.SigningMethodRS256, id, w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want %d when the proof is an ordinary membership signature, not a rotation-scoped token", w.Code, http.StatusUnauthorized)
+	}
+	if got, _ := src.Key(id); got != crypto.PublicKey(&oldKey.PublicKey) {
+		t.Fatalf("key was rotated despite a rejected proof")
+	}
+}
+
+func TestServeMemberKeyRotationAcceptsRotationScopedProof(t *testing.T) {
+	id := types.ID(42)
+	oldKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	src := NewStaticJWKSource()
+	src.SetKey(id, &oldKey.PublicKey)
+
+	proof, err := SignKeyRotationProof(oldKey, jwt.SigningMethodRS256, id)
+	if err != nil {
+		t.Fatalf("SignKeyRotationProof: %v", err)
+	}
+
+	body := pemEncodePublicKey(t, &newKey.PublicKey)
+	req := httptest.NewRequest(http.MethodPost, "/v2/members/"+id.String()+"/keys", strings.NewReader(string(body)))
+	req.Header.Set("Authorization", "Bearer "+proof)
+	w := httptest.NewRecorder()
+	ServeMemberKeyRotation(src, jwt.SigningMethodRS256, id, w, req)
+
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusNoContent, w.Body.String())
+	}
+	got, err := src.Key(id)
+	if err != nil {
+		t.Fatalf("Key: %v", err)
+	}
+	if got.(*rsa.PublicKey).N.Cmp(newKey.PublicKey.N) != 0 {
+		t.Fatalf("key was not rotated to the new public key")
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdserver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+const (
+	// CurrentMemberCapability is the attribute-encoding version this
+	// build understands and advertises. Members that only understand
+	// capability 0 predate the versioned envelope entirely and only
+	// ever see raw JSON.
+	CurrentMemberCapability = 2
+
+	attrEncodingGzip = "gzip+json"
+
+	// gzipThreshold is the minimum marshaled payload size, in bytes,
+	// above which the envelope gzip-compresses the payload. Below it
+	// gzip's framing overhead isn't worth paying.
+	gzipThreshold = 512
+)
+
+// attrEnvelope is the versioned on-disk wrapper around a JSON-marshaled
+// RaftAttributes or Attributes blob stored at raftAttributesSuffix or
+// attributesSuffix. A value at one of those keys that doesn't parse as
+// an envelope (no "v" field) is assumed to be the legacy raw JSON blob
+// written by peers that predate this envelope. Data is []byte, which
+// encoding/json represents as a base64 string on the wire.
+type attrEnvelope struct {
+	V    int    `json:"v"`
+	Enc  string `json:"enc,omitempty"`
+	Data []byte `json:"data"`
+}
+
+// marshalAttr encodes v (a RaftAttributes or Attributes value) as a
+// CurrentMemberCapability envelope, gzip-compressing the payload once it
+// exceeds gzipThreshold.
+func marshalAttr(v interface{}) ([]byte, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	env := attrEnvelope{V: CurrentMemberCapability, Data: raw}
+	if len(raw) > gzipThreshold {
+		var buf bytes.Buffer
+		gw := gzip.NewWriter(&buf)
+		if _, err := gw.Write(raw); err != nil {
+			return nil, err
+		}
+		if err := gw.Close(); err != nil {
+			return nil, err
+		}
+		env.Enc = attrEncodingGzip
+		env.Data = buf.Bytes()
+	}
+	return json.Marshal(env)
+}
+
+// unmarshalAttr decodes data into v, transparently handling both the
+// legacy raw JSON format and the versioned envelope, gzip-compressed or
+// not.
+func unmarshalAttr(data []byte, v interface{}) error {
+	var env attrEnvelope
+	if err := json.Unmarshal(data, &env); err != nil || env.V == 0 {
+		// Doesn't parse as an envelope, or parsed but without a "v"
+		// field: assume legacy raw JSON.
+		return json.Unmarshal(data, v)
+	}
+
+	payload := env.Data
+	if env.Enc == attrEncodingGzip {
+		gr, err := gzip.NewReader(bytes.NewReader(env.Data))
+		if err != nil {
+			return fmt.Errorf("unmarshal attr: bad gzip envelope: %v", err)
+		}
+		defer gr.Close()
+		if payload, err = ioutil.ReadAll(gr); err != nil {
+			return fmt.Errorf("unmarshal attr: bad gzip envelope: %v", err)
+		}
+	}
+	return json.Unmarshal(payload, v)
+}
+
+// MarshalRaftAttributes encodes m.RaftAttributes for storage, using the
+// versioned envelope when useEnvelope is true and plain legacy JSON
+// otherwise.
+func (m *Member) MarshalRaftAttributes(useEnvelope bool) ([]byte, error) {
+	if useEnvelope {
+		return marshalAttr(m.RaftAttributes)
+	}
+	return json.Marshal(m.RaftAttributes)
+}
+
+// MarshalAttributes encodes m.Attributes for storage, using the
+// versioned envelope when useEnvelope is true and plain legacy JSON
+// otherwise.
+func (m *Member) MarshalAttributes(useEnvelope bool) ([]byte, error) {
+	if useEnvelope {
+		return marshalAttr(m.Attributes)
+	}
+	return json.Marshal(m.Attributes)
+}
+
+// MinCapability returns the lowest CurrentMemberCapability advertised by
+// any member of the cluster. It is 0 whenever the cluster has no members
+// or any member hasn't advertised a capability, meaning writers must
+// stick to legacy raw JSON until every peer has upgraded.
+func (c *Cluster) MinCapability() int {
+	members := c.Members()
+	if len(members) == 0 {
+		return 0
+	}
+	lowest := CurrentMemberCapability
+	for _, m := range members {
+		if m.Capability < lowest {
+			lowest = m.Capability
+		}
+	}
+	return lowest
+}
+
+// useAttrEnvelope reports whether the cluster's members all understand
+// the versioned envelope, i.e. whether it's safe to start writing it.
+func (c *Cluster) useAttrEnvelope() bool {
+	return c.MinCapability() >= CurrentMemberCapability
+}
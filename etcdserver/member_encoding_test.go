@@ -0,0 +1,92 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestMarshalAttrRoundTrip(t *testing.T) {
+	want := Attributes{Name: "node1", ClientURLs: []string{"http://a:1", "http://b:2"}}
+
+	data, err := marshalAttr(want)
+	if err != nil {
+		t.Fatalf("marshalAttr: %v", err)
+	}
+
+	var got Attributes
+	if err := unmarshalAttr(data, &got); err != nil {
+		t.Fatalf("unmarshalAttr: %v", err)
+	}
+	if got.Name != want.Name || len(got.ClientURLs) != len(want.ClientURLs) {
+		t.Fatalf("round trip mismatch: got %+v, want %+v", got, want)
+	}
+}
+
+func TestMarshalAttrGzipsLargePayloads(t *testing.T) {
+	urls := make([]string, 0, 64)
+	for i := 0; i < 64; i++ {
+		urls = append(urls, fmt.Sprintf("http://peer-%d.example.com:2380", i))
+	}
+	want := RaftAttributes{PeerURLs: urls}
+
+	data, err := marshalAttr(want)
+	if err != nil {
+		t.Fatalf("marshalAttr: %v", err)
+	}
+
+	var env attrEnvelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		t.Fatalf("decode envelope: %v", err)
+	}
+	if env.Enc != attrEncodingGzip {
+		t.Fatalf("enc = %q, want %q for a payload above gzipThreshold", env.Enc, attrEncodingGzip)
+	}
+
+	var got RaftAttributes
+	if err := unmarshalAttr(data, &got); err != nil {
+		t.Fatalf("unmarshalAttr: %v", err)
+	}
+	if len(got.PeerURLs) != len(want.PeerURLs) {
+		t.Fatalf("round trip mismatch: got %d urls, want %d", len(got.PeerURLs), len(want.PeerURLs))
+	}
+}
+
+func TestUnmarshalAttrFallsBackToLegacyJSON(t *testing.T) {
+	legacy := []byte(`{"name":"legacy","clientURLs":["http://x:1"]}`)
+
+	var got Attributes
+	if err := unmarshalAttr(legacy, &got); err != nil {
+		t.Fatalf("unmarshalAttr: %v", err)
+	}
+	if got.Name != "legacy" || len(got.ClientURLs) != 1 {
+		t.Fatalf("legacy decode mismatch: %+v", got)
+	}
+}
+
+func TestClusterMinCapability(t *testing.T) {
+	c := NewClusterFromMembers("test", []*Member{
+		{ID: 1, Attributes: Attributes{Capability: CurrentMemberCapability}},
+		{ID: 2, Attributes: Attributes{Capability: 1}},
+	})
+	if got := c.MinCapability(); got != 1 {
+		t.Fatalf("MinCapability = %d, want 1 (the lowest advertised)", got)
+	}
+	if c.useAttrEnvelope() {
+		t.Fatalf("useAttrEnvelope should be false until every member reaches CurrentMemberCapability")
+	}
+}
@@ -0,0 +1,449 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdserver
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd/pkg/types"
+)
+
+const (
+	// defaultPhiThreshold is the suspicion level above which a member is
+	// considered down by the accrual failure detector. 8.0 corresponds
+	// to roughly a 1-in-100-million chance of a false positive once the
+	// detector has enough samples to estimate the distribution.
+	defaultPhiThreshold = 8.0
+
+	// heartbeatWindowSize bounds the ring buffer of inter-arrival samples
+	// kept per member, so the mean/stddev estimate adapts to recent
+	// network conditions instead of being skewed by old history.
+	heartbeatWindowSize = 1000
+
+	// minSamplesForEstimate is the number of inter-arrival samples
+	// required before Phi trusts the mean/stddev estimate rather than
+	// falling back to a fixed timeout.
+	minSamplesForEstimate = 2
+
+	// fallbackTimeout is used as a stand-in for Phi until enough samples
+	// have been collected to compute a distribution.
+	fallbackTimeout = 3 * time.Second
+
+	// rttWindowSize bounds the per-URL round-trip-time history kept for
+	// LowestRTTFirstSelector, so it tracks recent latency rather than an
+	// all-time average.
+	rttWindowSize = 8
+)
+
+// MemberHealth tracks liveness information for a single cluster member, as
+// observed by the local Prober. It implements a Phi Accrual failure
+// detector (Hayashibara et al., "The phi accrual failure detector"):
+// instead of declaring a member down after a fixed timeout, it keeps a
+// sliding window of observed heartbeat intervals and derives a suspicion
+// level that adapts to GC pauses and WAN jitter.
+type MemberHealth struct {
+	mu sync.Mutex
+
+	id types.ID
+
+	lastSeen time.Time
+	failures int
+
+	intervals []time.Duration // ring buffer of inter-arrival times
+	next      int             // total samples ever pushed
+
+	// urlHealth records, per peer URL, whether the most recent probe of
+	// that URL succeeded. PickPeerURL and the PeerSelectors in
+	// member_topology.go do not read it.
+	urlHealth map[string]bool
+
+	// urlRTT keeps a short rolling history of observed round-trip times
+	// per peer URL, so a PeerSelector can prefer low-latency peers. See
+	// LowestRTTFirstSelector in member_topology.go.
+	urlRTT map[string][]time.Duration
+
+	// suspectedAt is the time the member first crossed the suspicion
+	// threshold since its last successful heartbeat. It is zero while
+	// the member is considered healthy.
+	suspectedAt time.Time
+
+	// evictionProposed is set once a ConfChangeRemoveNode has been
+	// proposed for the member's current suspicion episode, so the
+	// Prober doesn't re-propose it on every subsequent probe tick while
+	// waiting for the change to commit. It is cleared when the member
+	// recovers or the proposal fails.
+	evictionProposed bool
+}
+
+// recordSuccess registers a successful probe of url at time now, along
+// with the round-trip time the probe took.
+func (h *MemberHealth) recordSuccess(url string, now time.Time, rtt time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.lastSeen.IsZero() && now.After(h.lastSeen) {
+		h.pushInterval(now.Sub(h.lastSeen))
+	}
+	h.lastSeen = now
+	h.failures = 0
+	h.suspectedAt = time.Time{}
+	h.evictionProposed = false
+	if h.urlHealth == nil {
+		h.urlHealth = make(map[string]bool)
+	}
+	h.urlHealth[url] = true
+
+	if h.urlRTT == nil {
+		h.urlRTT = make(map[string][]time.Duration)
+	}
+	samples := append(h.urlRTT[url], rtt)
+	if len(samples) > rttWindowSize {
+		samples = samples[len(samples)-rttWindowSize:]
+	}
+	h.urlRTT[url] = samples
+}
+
+// meanRTT returns the mean of the recently observed round-trip times for
+// url, and whether any samples have been recorded for it at all.
+func (h *MemberHealth) meanRTT(url string) (mean time.Duration, ok bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	samples := h.urlRTT[url]
+	if len(samples) == 0 {
+		return 0, false
+	}
+	var sum time.Duration
+	for _, s := range samples {
+		sum += s
+	}
+	return sum / time.Duration(len(samples)), true
+}
+
+// recordFailure registers a failed probe of url.
+func (h *MemberHealth) recordFailure(url string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.failures++
+	if h.urlHealth == nil {
+		h.urlHealth = make(map[string]bool)
+	}
+	h.urlHealth[url] = false
+}
+
+func (h *MemberHealth) pushInterval(d time.Duration) {
+	if h.intervals == nil {
+		h.intervals = make([]time.Duration, heartbeatWindowSize)
+	}
+	h.intervals[h.next%heartbeatWindowSize] = d
+	h.next++
+}
+
+func (h *MemberHealth) meanStddev() (mean, stddev float64, n int) {
+	n = h.next
+	if n > heartbeatWindowSize {
+		n = heartbeatWindowSize
+	}
+	if n == 0 {
+		return 0, 0, 0
+	}
+	var sum float64
+	for i := 0; i < n; i++ {
+		sum += float64(h.intervals[i])
+	}
+	mean = sum / float64(n)
+	var sq float64
+	for i := 0; i < n; i++ {
+		d := float64(h.intervals[i]) - mean
+		sq += d * d
+	}
+	stddev = math.Sqrt(sq / float64(n))
+	return mean, stddev, n
+}
+
+// Phi returns the member's current suspicion level at time now. A value
+// at or above defaultPhiThreshold means the member is suspected down.
+func (h *MemberHealth) Phi(now time.Time) float64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.phiLocked(now)
+}
+
+func (h *MemberHealth) phiLocked(now time.Time) float64 {
+	if h.lastSeen.IsZero() {
+		return 0
+	}
+	elapsed := now.Sub(h.lastSeen)
+	mean, stddev, n := h.meanStddev()
+	if n < minSamplesForEstimate || stddev == 0 {
+		if elapsed > fallbackTimeout {
+			return defaultPhiThreshold + 1
+		}
+		return 0
+	}
+	y := (float64(elapsed) - mean) / stddev
+	p := 1 - normalCDF(y)
+	if p <= 0 {
+		return 1000 // indistinguishable from certainly down
+	}
+	return -math.Log10(p)
+}
+
+func normalCDF(x float64) float64 {
+	return 0.5 * (1 + math.Erf(x/math.Sqrt2))
+}
+
+// Suspected reports whether the member's Phi value at time now is at or
+// above threshold, and tracks suspectedAt for eviction grace windows.
+func (h *MemberHealth) Suspected(now time.Time, threshold float64) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	phi := h.phiLocked(now)
+	if phi < threshold {
+		h.suspectedAt = time.Time{}
+		h.evictionProposed = false
+		return false
+	}
+	if h.suspectedAt.IsZero() {
+		h.suspectedAt = now
+	}
+	return true
+}
+
+// SuspectedFor returns how long the member has been continuously
+// suspected as of now, or zero if it is currently healthy.
+func (h *MemberHealth) SuspectedFor(now time.Time) time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.suspectedAt.IsZero() {
+		return 0
+	}
+	return now.Sub(h.suspectedAt)
+}
+
+// markEvictionProposed reports whether an eviction proposal is already
+// in flight for the member's current suspicion episode. If not, it
+// records one as in flight and returns false.
+func (h *MemberHealth) markEvictionProposed() (alreadyProposed bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	alreadyProposed = h.evictionProposed
+	h.evictionProposed = true
+	return alreadyProposed
+}
+
+// clearEvictionProposed allows a future probe tick to retry proposing
+// eviction, e.g. after the previous proposal failed.
+func (h *MemberHealth) clearEvictionProposed() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.evictionProposed = false
+}
+
+// healthFor returns (creating if necessary) the MemberHealth tracked for
+// id.
+func (c *Cluster) healthFor(id types.ID) *MemberHealth {
+	c.Lock()
+	defer c.Unlock()
+	if c.health == nil {
+		c.health = make(map[types.ID]*MemberHealth)
+	}
+	h, ok := c.health[id]
+	if !ok {
+		h = &MemberHealth{id: id}
+		c.health[id] = h
+	}
+	return h
+}
+
+// MemberHealth returns the health record tracked for id.
+func (c *Cluster) MemberHealth(id types.ID) *MemberHealth {
+	return c.healthFor(id)
+}
+
+// Healthy reports whether every member of the cluster is currently below
+// the suspicion threshold.
+func (c *Cluster) Healthy() bool {
+	now := time.Now()
+	for _, m := range c.Members() {
+		if c.healthFor(m.ID).Suspected(now, defaultPhiThreshold) {
+			return false
+		}
+	}
+	return true
+}
+
+// RaftConfChanger proposes a membership change to the underlying raft
+// node. No type in this package implements it; the Prober depends on
+// the interface rather than raft directly so it stays testable in
+// isolation.
+type RaftConfChanger interface {
+	ProposeConfChangeRemoveNode(id types.ID) error
+}
+
+// Prober periodically probes every member of a cluster over its
+// PeerURLs and feeds the results into the cluster's MemberHealth
+// tracking, optionally auto-evicting members that stay suspected past a
+// grace window.
+type Prober struct {
+	cluster *Cluster
+	tr      *http.Transport
+
+	// Interval is the time between probe rounds.
+	Interval time.Duration
+	// PhiThreshold is the suspicion level above which a member is
+	// considered down.
+	PhiThreshold float64
+	// EvictAfter is how long a member may stay continuously suspected
+	// before the prober proposes its removal. Zero disables eviction.
+	EvictAfter time.Duration
+	// Raft proposes the ConfChangeRemoveNode used for auto-eviction. May
+	// be nil, in which case eviction is a no-op.
+	Raft RaftConfChanger
+
+	stopc chan struct{}
+}
+
+// NewProber creates a Prober for cluster using tr to dial peers.
+func NewProber(cluster *Cluster, tr *http.Transport) *Prober {
+	return &Prober{
+		cluster:      cluster,
+		tr:           tr,
+		Interval:     time.Second,
+		PhiThreshold: defaultPhiThreshold,
+		stopc:        make(chan struct{}),
+	}
+}
+
+// Run probes the cluster every p.Interval until Stop is called. It is
+// meant to be run in its own goroutine.
+func (p *Prober) Run() {
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.probeAll()
+		case <-p.stopc:
+			return
+		}
+	}
+}
+
+// Stop terminates Run.
+func (p *Prober) Stop() { close(p.stopc) }
+
+func (p *Prober) probeAll() {
+	for _, m := range p.cluster.Members() {
+		go p.probeMember(m)
+	}
+}
+
+func (p *Prober) probeMember(m *Member) {
+	h := p.cluster.healthFor(m.ID)
+
+	var wg sync.WaitGroup
+	for _, u := range m.PeerURLs {
+		wg.Add(1)
+		go func(url string) {
+			defer wg.Done()
+			p.probeURL(h, url)
+		}(u)
+	}
+	wg.Wait()
+
+	threshold := p.PhiThreshold
+	if threshold == 0 {
+		threshold = defaultPhiThreshold
+	}
+	now := time.Now()
+	if !h.Suspected(now, threshold) {
+		return
+	}
+	if p.EvictAfter == 0 || p.Raft == nil || h.SuspectedFor(now) < p.EvictAfter {
+		return
+	}
+	if h.markEvictionProposed() {
+		// Already proposed for this suspicion episode; wait for it to
+		// commit (or for the member to recover) instead of flooding
+		// raft with duplicate proposals every probe tick.
+		return
+	}
+	if err := p.Raft.ProposeConfChangeRemoveNode(m.ID); err != nil {
+		log.Printf("etcdserver: failed to propose removal of suspected member %s: %v", m.ID, err)
+		h.clearEvictionProposed()
+	}
+}
+
+// probeURL checks url's /health and /version endpoints, recording a
+// single success only if both respond, and timing the round trip across
+// both requests so LowestRTTFirstSelector has real latency data to rank
+// peer URLs by.
+func (p *Prober) probeURL(h *MemberHealth, url string) {
+	cc := &http.Client{Transport: p.tr, Timeout: time.Second}
+	start := time.Now()
+
+	resp, err := cc.Get(url + "/health")
+	if err != nil {
+		h.recordFailure(url)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		h.recordFailure(url)
+		return
+	}
+
+	resp, err = cc.Get(url + "/version")
+	if err != nil {
+		h.recordFailure(url)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		h.recordFailure(url)
+		return
+	}
+
+	h.recordSuccess(url, time.Now(), time.Since(start))
+}
+
+// memberHealthJSON is the wire representation returned by ServeHealth.
+type memberHealthJSON struct {
+	ID      string  `json:"id"`
+	Healthy bool    `json:"healthy"`
+	Phi     float64 `json:"phi"`
+}
+
+// ServeHealth is an http.HandlerFunc that reports the health of every
+// cluster member as seen by the local Prober.
+func (c *Cluster) ServeHealth(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	members := c.Members()
+	out := make([]memberHealthJSON, 0, len(members))
+	for _, m := range members {
+		phi := c.healthFor(m.ID).Phi(now)
+		out = append(out, memberHealthJSON{
+			ID:      m.ID.String(),
+			Healthy: phi < defaultPhiThreshold,
+			Phi:     phi,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
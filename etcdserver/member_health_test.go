@@ -0,0 +1,104 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdserver
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemberHealthPhiRisesWithSilence(t *testing.T) {
+	h := &MemberHealth{id: 1}
+	interval := 100 * time.Millisecond
+	jitter := []time.Duration{0, 10 * time.Millisecond, -10 * time.Millisecond, 5 * time.Millisecond, -5 * time.Millisecond}
+
+	last := time.Unix(1700000000, 0)
+	h.recordSuccess("http://a", last, 5*time.Millisecond)
+	for i := 1; i < 50; i++ {
+		last = last.Add(interval + jitter[i%len(jitter)])
+		h.recordSuccess("http://a", last, 5*time.Millisecond)
+	}
+
+	if phi := h.Phi(last.Add(interval)); phi >= defaultPhiThreshold {
+		t.Fatalf("phi right on schedule = %v, want well below threshold %v", phi, defaultPhiThreshold)
+	}
+	if phi := h.Phi(last.Add(50 * interval)); phi < defaultPhiThreshold {
+		t.Fatalf("phi after long silence = %v, want >= threshold %v", phi, defaultPhiThreshold)
+	}
+}
+
+func TestMemberHealthSuspectedRecovers(t *testing.T) {
+	h := &MemberHealth{id: 1}
+	now := time.Now()
+	h.recordSuccess("http://a", now, time.Millisecond)
+
+	later := now.Add(10 * time.Second)
+	if !h.Suspected(later, defaultPhiThreshold) {
+		t.Fatalf("expected member to be suspected after a long silence")
+	}
+	evenLater := later.Add(time.Second)
+	if d := h.SuspectedFor(evenLater); d <= 0 {
+		t.Fatalf("SuspectedFor = %v, want > 0 once time has passed since the member was first suspected", d)
+	}
+
+	h.recordSuccess("http://a", later, time.Millisecond)
+	if h.Suspected(later, defaultPhiThreshold) {
+		t.Fatalf("expected member to recover after a fresh heartbeat")
+	}
+	if d := h.SuspectedFor(later); d != 0 {
+		t.Fatalf("SuspectedFor after recovery = %v, want 0", d)
+	}
+}
+
+func TestMemberHealthEvictionDebounce(t *testing.T) {
+	h := &MemberHealth{id: 1}
+
+	if h.markEvictionProposed() {
+		t.Fatalf("first markEvictionProposed should report no proposal in flight yet")
+	}
+	if !h.markEvictionProposed() {
+		t.Fatalf("second markEvictionProposed should report a proposal already in flight")
+	}
+
+	h.clearEvictionProposed()
+	if h.markEvictionProposed() {
+		t.Fatalf("after clearEvictionProposed, should report no proposal in flight")
+	}
+
+	// Recovering resets the debounce too.
+	h.evictionProposed = true
+	h.recordSuccess("http://a", time.Now(), time.Millisecond)
+	if h.markEvictionProposed() {
+		t.Fatalf("after recordSuccess, should report no proposal in flight")
+	}
+}
+
+func TestMemberHealthMeanRTT(t *testing.T) {
+	h := &MemberHealth{id: 1}
+	if _, ok := h.meanRTT("http://a"); ok {
+		t.Fatalf("expected no RTT samples before any probe")
+	}
+
+	h.recordSuccess("http://a", time.Now(), 10*time.Millisecond)
+	h.recordSuccess("http://a", time.Now(), 20*time.Millisecond)
+
+	mean, ok := h.meanRTT("http://a")
+	if !ok {
+		t.Fatalf("expected RTT samples after probing")
+	}
+	if want := 15 * time.Millisecond; mean != want {
+		t.Fatalf("meanRTT = %v, want %v", mean, want)
+	}
+}
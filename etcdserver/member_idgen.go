@@ -0,0 +1,145 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdserver
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/coreos/etcd/pkg/types"
+)
+
+// MemberIDGenerator derives the types.ID to assign a new member given its
+// name, peer URLs, and the name of the cluster it is joining.
+// Implementations should avoid returning a zero ID.
+type MemberIDGenerator interface {
+	Generate(name string, peerURLs types.URLs, clusterName string, now *time.Time) types.ID
+}
+
+// SHA1Generator is the original id scheme: it hashes the sorted peer
+// URLs, the cluster name and an optional timestamp with SHA-1 and takes
+// the top 64 bits. It is deterministic given the same inputs, which is
+// also its main weakness: clusters that add and remove members with
+// reused names and peer URLs can derive the same id twice.
+type SHA1Generator struct{}
+
+// Generate implements MemberIDGenerator.
+func (SHA1Generator) Generate(name string, peerURLs types.URLs, clusterName string, now *time.Time) types.ID {
+	var b []byte
+	urls := peerURLs.StringSlice()
+	sort.Strings(urls)
+	for _, p := range urls {
+		b = append(b, []byte(p)...)
+	}
+	b = append(b, []byte(clusterName)...)
+	if now != nil {
+		b = append(b, []byte(fmt.Sprintf("%d", now.Unix()))...)
+	}
+	hash := sha1.Sum(b)
+	return types.ID(binary.BigEndian.Uint64(hash[:8]))
+}
+
+// UUIDv7Generator derives a time-ordered id: the high 48 bits carry a
+// millisecond timestamp, so ids sort roughly by creation time even
+// across members with identical names or peer URLs, and the low 16
+// bits are random to separate ids minted in the same millisecond.
+type UUIDv7Generator struct{}
+
+// Generate implements MemberIDGenerator.
+func (UUIDv7Generator) Generate(name string, peerURLs types.URLs, clusterName string, now *time.Time) types.ID {
+	t := time.Now()
+	if now != nil {
+		t = *now
+	}
+	ms := uint64(t.UnixNano()/int64(time.Millisecond)) & 0xFFFFFFFFFFFF
+
+	var rnd [2]byte
+	if _, err := rand.Read(rnd[:]); err != nil {
+		binary.BigEndian.PutUint16(rnd[:], uint16(t.Nanosecond()))
+	}
+	return types.ID(ms<<16 | uint64(binary.BigEndian.Uint16(rnd[:])))
+}
+
+// MonotonicGenerator derives ids from an in-memory counter on Cluster,
+// so ids are small and easy to read in logs. The counter is seeded from
+// the highest member id present when the Cluster was constructed (see
+// NewClusterFromMembers), so a restarted process resumes counting past
+// ids already in use rather than re-walking over them from zero — but
+// it is not itself persisted or replicated through raft, so concurrent
+// id assignment from more than one process (e.g. during a split-brain)
+// can still collide. MonotonicGenerator is best suited to
+// single-authority bootstrap flows, not coordinated multi-node id
+// assignment.
+type MonotonicGenerator struct {
+	Cluster *Cluster
+}
+
+// Generate implements MemberIDGenerator.
+func (g MonotonicGenerator) Generate(name string, peerURLs types.URLs, clusterName string, now *time.Time) types.ID {
+	return g.Cluster.nextMonotonicID()
+}
+
+// nextMonotonicID returns the next value of the cluster's id counter.
+func (c *Cluster) nextMonotonicID() types.ID {
+	return types.ID(atomic.AddUint64(&c.idCounter, 1))
+}
+
+// maxIDGenAttempts bounds AddMemberWithGenerator's collision-retry loop.
+// SHA1Generator only has second resolution (it hashes now.Unix()), so
+// retries must perturb by whole seconds to actually change its output;
+// this many attempts is far more than any real collision run should
+// need and keeps a persistently-colliding generator from looping forever.
+const maxIDGenAttempts = 64
+
+// AddMemberWithGenerator builds a new Member for name/peerURLs using gen,
+// retrying with a perturbed timestamp whenever the generated id collides
+// with an existing or previously-removed member, then adds the member to
+// the cluster. It returns an error if no collision-free id was found
+// within maxIDGenAttempts.
+func (c *Cluster) AddMemberWithGenerator(gen MemberIDGenerator, name string, peerURLs types.URLs, now *time.Time) (*Member, error) {
+	t := time.Now()
+	if now != nil {
+		t = *now
+	}
+	for attempt := 0; attempt < maxIDGenAttempts; attempt++ {
+		// Perturb by whole seconds: SHA1Generator reads now.Unix(), so
+		// anything finer than a second would regenerate the same id on
+		// every retry and never terminate.
+		tt := t.Add(time.Duration(attempt) * time.Second)
+		id := gen.Generate(name, peerURLs, c.name, &tt)
+
+		c.Lock()
+		_, exists := c.members[id]
+		removed := c.removed[id]
+		c.Unlock()
+		if id == 0 || exists || removed {
+			continue
+		}
+
+		m := &Member{
+			ID:             id,
+			RaftAttributes: RaftAttributes{PeerURLs: peerURLs.StringSlice()},
+			Attributes:     Attributes{Name: name, Capability: CurrentMemberCapability},
+		}
+		c.AddMember(m)
+		return m, nil
+	}
+	return nil, fmt.Errorf("etcdserver: failed to generate a unique member id for %q after %d attempts", name, maxIDGenAttempts)
+}
@@ -0,0 +1,100 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdserver
+
+import (
+	"math/rand"
+	"time"
+)
+
+// PeerSelector picks a peer URL for member m, given whatever topology or
+// health context the implementation was built with. SelectPeerURL
+// returns "" to defer to PickPeerURL's default uniform-random behavior,
+// e.g. because none of m's URLs match the policy.
+type PeerSelector interface {
+	SelectPeerURL(m *Member) string
+}
+
+// SameZoneFirstSelector returns a uniformly random peer URL of m when m
+// shares the "zone" label with the local member, and "" otherwise.
+// Labels are per member, not per URL, so the URL it returns is drawn the
+// same way as PickPeerURL's default; it does not steer traffic between
+// members.
+type SameZoneFirstSelector struct {
+	// LocalLabels are the labels of the member doing the selecting.
+	LocalLabels map[string]string
+}
+
+// SelectPeerURL implements PeerSelector.
+func (s SameZoneFirstSelector) SelectPeerURL(m *Member) string {
+	zone := s.LocalLabels["zone"]
+	if zone == "" || m.Labels["zone"] != zone {
+		return ""
+	}
+	return m.PeerURLs[rand.Intn(len(m.PeerURLs))]
+}
+
+// LowestRTTFirstSelector prefers the peer URL with the lowest mean
+// round-trip time recorded by the health subsystem's Prober. It returns
+// "" (deferring to uniform random) when none of m's URLs have any
+// recorded samples yet.
+type LowestRTTFirstSelector struct {
+	Cluster *Cluster
+}
+
+// SelectPeerURL implements PeerSelector.
+func (s LowestRTTFirstSelector) SelectPeerURL(m *Member) string {
+	h := s.Cluster.healthFor(m.ID)
+
+	best := ""
+	var bestRTT time.Duration
+	for _, u := range m.PeerURLs {
+		rtt, ok := h.meanRTT(u)
+		if !ok {
+			continue
+		}
+		if best == "" || rtt < bestRTT {
+			best, bestRTT = u, rtt
+		}
+	}
+	return best
+}
+
+// SortableMemberSliceByLabel sorts members by an arbitrary label key.
+// Members that lack the label sort last. Less treats members with equal
+// values, or both lacking the label, as equal, so their relative order
+// is kept only when sorted with sort.Stable.
+type SortableMemberSliceByLabel struct {
+	Members []*Member
+	Key     string
+}
+
+func (s SortableMemberSliceByLabel) Len() int { return len(s.Members) }
+
+func (s SortableMemberSliceByLabel) Less(i, j int) bool {
+	li, oki := s.Members[i].Labels[s.Key]
+	lj, okj := s.Members[j].Labels[s.Key]
+	if !oki {
+		return false
+	}
+	if !okj {
+		return true
+	}
+	return li < lj
+}
+
+func (s SortableMemberSliceByLabel) Swap(i, j int) {
+	s.Members[i], s.Members[j] = s.Members[j], s.Members[i]
+}
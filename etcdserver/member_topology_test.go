@@ -0,0 +1,65 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdserver
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/pkg/types"
+)
+
+func TestSortableMemberSliceByLabel(t *testing.T) {
+	members := []*Member{
+		{ID: 1, Attributes: Attributes{Labels: map[string]string{"zone": "c"}}},
+		{ID: 2, Attributes: Attributes{Labels: map[string]string{"zone": "a"}}},
+		{ID: 3, Attributes: Attributes{}}, // no zone label: sorts last
+	}
+	sort.Sort(SortableMemberSliceByLabel{Members: members, Key: "zone"})
+
+	want := []types.ID{2, 1, 3}
+	for i, m := range members {
+		if m.ID != want[i] {
+			t.Fatalf("position %d: got id %v, want %v", i, m.ID, want[i])
+		}
+	}
+}
+
+func TestLowestRTTFirstSelectorPrefersFasterURL(t *testing.T) {
+	c := NewClusterFromMembers("test", nil)
+	m := &Member{ID: 7, RaftAttributes: RaftAttributes{PeerURLs: []string{"http://slow:1", "http://fast:1"}}}
+	c.AddMember(m)
+
+	h := c.healthFor(m.ID)
+	h.recordSuccess("http://slow:1", time.Now(), 50*time.Millisecond)
+	h.recordSuccess("http://fast:1", time.Now(), 5*time.Millisecond)
+
+	sel := LowestRTTFirstSelector{Cluster: c}
+	if got := sel.SelectPeerURL(m); got != "http://fast:1" {
+		t.Fatalf("SelectPeerURL = %q, want %q", got, "http://fast:1")
+	}
+}
+
+func TestLowestRTTFirstSelectorDefersWithoutSamples(t *testing.T) {
+	c := NewClusterFromMembers("test", nil)
+	m := &Member{ID: 7, RaftAttributes: RaftAttributes{PeerURLs: []string{"http://a:1"}}}
+	c.AddMember(m)
+
+	sel := LowestRTTFirstSelector{Cluster: c}
+	if got := sel.SelectPeerURL(m); got != "" {
+		t.Fatalf("SelectPeerURL = %q, want \"\" when no RTT samples are recorded", got)
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdserver
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/coreos/etcd/pkg/types"
+)
+
+// ServerConfig holds the bootstrap-time configuration that AddLocalMember
+// uses to mint the local Member and to configure the Cluster's peer
+// authentication.
+type ServerConfig struct {
+	Name        string
+	ClusterName string
+
+	// MemberIDGenerator selects the scheme used to derive ids for new
+	// members. Defaults to SHA1Generator when nil, matching historical
+	// behavior.
+	MemberIDGenerator MemberIDGenerator
+
+	// PeerAuthenticator, if set, signs this member's own records and
+	// verifies the signatures of members presented to the cluster. See
+	// member_auth.go.
+	PeerAuthenticator PeerAuthenticator
+	// PeerAuthRequired mirrors --peer-auth=required: when true, members
+	// without a valid signature are rejected; when false, unsigned members
+	// joining under a new id are admitted (see Cluster.AdmitMember).
+	PeerAuthRequired bool
+}
+
+// memberIDGenerator returns c.MemberIDGenerator, defaulting to
+// SHA1Generator so clusters that don't configure a generator keep the
+// original id scheme.
+func (c *ServerConfig) memberIDGenerator() MemberIDGenerator {
+	if c.MemberIDGenerator != nil {
+		return c.MemberIDGenerator
+	}
+	return SHA1Generator{}
+}
+
+// AddLocalMember builds and adds to cluster the Member for the server
+// described by cfg, using cfg.MemberIDGenerator (or SHA1Generator by
+// default). This is the bootstrap-time entry point that ties
+// ServerConfig.MemberIDGenerator to an actual id assignment: it mints
+// and registers the local Member under cfg.Name and peerURLs.
+//
+// If cfg.PeerAuthenticator is set, AddLocalMember also configures it as
+// the cluster's authenticator (so AdmitMember checks it against members
+// presented by peers, per cfg.PeerAuthRequired) and signs the local
+// member with it, exactly as a peer running with --peer-auth would need
+// to in order to be admitted by others. Without a PeerAuthenticator, the
+// local member is added unsigned, matching historical behavior.
+func (cfg *ServerConfig) AddLocalMember(cluster *Cluster, peerURLs types.URLs, now *time.Time) (*Member, error) {
+	if cfg.PeerAuthenticator != nil {
+		cluster.SetPeerAuthenticator(cfg.PeerAuthenticator, cfg.PeerAuthRequired)
+	}
+
+	m, err := cluster.AddMemberWithGenerator(cfg.memberIDGenerator(), cfg.Name, peerURLs, now)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.PeerAuthenticator == nil {
+		return m, nil
+	}
+
+	if now != nil {
+		m.IssuedAt = now.Unix()
+	} else {
+		m.IssuedAt = time.Now().Unix()
+	}
+	sig, err := cfg.PeerAuthenticator.Sign(m)
+	if err != nil {
+		return nil, fmt.Errorf("etcdserver: failed to sign local member %s: %v", m.ID, err)
+	}
+	m.Signature = sig
+	cluster.AddMember(m)
+	return m, nil
+}
@@ -0,0 +1,63 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdserver
+
+import (
+	"testing"
+
+	"github.com/coreos/etcd/pkg/types"
+)
+
+func TestAddLocalMemberWithoutPeerAuthenticatorAddsUnsigned(t *testing.T) {
+	cfg := &ServerConfig{Name: "node1", ClusterName: "test"}
+	c := NewClusterFromMembers(cfg.ClusterName, nil)
+
+	m, err := cfg.AddLocalMember(c, types.URLs{"http://a:1"}, nil)
+	if err != nil {
+		t.Fatalf("AddLocalMember: %v", err)
+	}
+	if m.Signature != "" {
+		t.Fatalf("expected an unsigned member when ServerConfig has no PeerAuthenticator")
+	}
+	if c.Member(m.ID) == nil {
+		t.Fatalf("member was not added to the cluster")
+	}
+}
+
+func TestAddLocalMemberWithPeerAuthenticatorSignsAndConfiguresCluster(t *testing.T) {
+	c := NewClusterFromMembers("test", nil)
+	cfg := &ServerConfig{Name: "node1", ClusterName: "test"}
+	auth, _ := newTestJWTAuthenticator(t, 1)
+	cfg.PeerAuthenticator = auth
+	cfg.PeerAuthRequired = true
+
+	m, err := cfg.AddLocalMember(c, types.URLs{"http://a:1"}, nil)
+	if err != nil {
+		t.Fatalf("AddLocalMember: %v", err)
+	}
+	if m.Signature == "" {
+		t.Fatalf("expected AddLocalMember to sign the local member when a PeerAuthenticator is configured")
+	}
+	if m.IssuedAt == 0 {
+		t.Fatalf("expected AddLocalMember to stamp IssuedAt on the signed member")
+	}
+
+	// AddLocalMember must have wired the authenticator into the cluster
+	// so that a second, unsigned member presented to it is rejected.
+	other := &Member{ID: 999, RaftAttributes: RaftAttributes{PeerURLs: []string{"http://b:1"}}}
+	if err := c.AdmitMember(other); err == nil {
+		t.Fatalf("expected AdmitMember to reject an unsigned member once AddLocalMember configured required peer auth")
+	}
+}